@@ -88,6 +88,14 @@ var (
 	verbose, defaults, noprompt, zipit, printVersion, override bool
 	dldir                                                      string
 	urls                                                       []string
+	loadFromFile                                               string
+	forceRedo                                                  bool
+	registryURL                                                string
+	output, s3AccessKey, s3SecretKey, s3Endpoint                string
+	s3UseSSL                                                    bool
+	segments                                                    int
+	minSegmentSize                                              int64
+	logFormat, logLevel                                         string
 )
 
 func init() {
@@ -109,10 +117,60 @@ func init() {
 		"Print the program version.")
 	flag.BoolVar(&override, "override", false,
 		"Override special options, such as forcing the number of workers.")
+	flag.StringVarP(&loadFromFile, "load-from-file", "f", "",
+		"Read URLs to download from a file, one per line. Lines starting with # are ignored.")
+	flag.BoolVar(&forceRedo, "force-redo", false,
+		"Set to ignore the resume state and redo URLs that were already completed.")
+	flag.StringVar(&registryURL, "registry", defaultRegistryURL,
+		"The URL of the plugin registry manifest used by \"mindl plugin\".")
+	flag.StringVar(&output, "output", "",
+		"Where to write downloaded files. Defaults to --directory on the local disk; pass e.g. s3://bucket/prefix to upload to an S3-compatible store instead.")
+	flag.StringVar(&s3AccessKey, "s3-access-key", "",
+		"Access key for the S3-compatible store used with --output. Falls back to $AWS_ACCESS_KEY_ID.")
+	flag.StringVar(&s3SecretKey, "s3-secret-key", "",
+		"Secret key for the S3-compatible store used with --output. Falls back to $AWS_SECRET_ACCESS_KEY.")
+	flag.StringVar(&s3Endpoint, "s3-endpoint", "",
+		"Endpoint of the S3-compatible store used with --output. Falls back to $S3_ENDPOINT.")
+	flag.BoolVar(&s3UseSSL, "s3-use-ssl", true,
+		"Set to use HTTPS when talking to the S3-compatible store.")
+	flag.IntVar(&segments, "segments", 1,
+		"The number of concurrent ranged requests to split direct file downloads into. 1 means the previous single-stream behavior.")
+	flag.Int64Var(&minSegmentSize, "min-segment-size", minSegmentSizeDefault,
+		"Files smaller than this, in bytes, are never split across multiple connections.")
+	flag.StringVar(&logFormat, "log-format", "text",
+		"Set to \"json\" to emit newline-delimited JSON log events on stdout instead of human-readable text, and suppress the progress line.")
+	flag.StringVar(&logLevel, "log-level", "",
+		"One of debug, info, warn, error. Supersedes --verbose when set.")
 
 	flag.CommandLine.MarkHidden("override")
 }
 
+// collectURLs gathers the URLs to process from the positional arguments
+// and, if --load-from-file is set, from the given file, deduplicating
+// the combined list while preserving order.
+func collectURLs(args []string) ([]string, error) {
+	if loadFromFile == "" {
+		return args, nil
+	}
+
+	fromFile, err := readURLsFromFile(loadFromFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --load-from-file: %w", err)
+	}
+
+	seen := make(map[string]bool, len(args)+len(fromFile))
+	merged := make([]string, 0, len(args)+len(fromFile))
+	for _, u := range append(args, fromFile...) {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		merged = append(merged, u)
+	}
+
+	return merged, nil
+}
+
 func main() {
 	flag.Parse()
 	if printVersion {
@@ -120,26 +178,93 @@ func main() {
 		os.Exit(0)
 	}
 
-	urls = flag.Args()
-	logger.Verbose(verbose)
+	if logFormat != "text" && logFormat != "json" {
+		log.Fatalf("Invalid --log-format: %s", logFormat)
+	}
+	if logLevel != "" {
+		if !isValidLogLevel(logLevel) {
+			logFatalf("Invalid --log-level: %s", logLevel)
+		}
+		activeLogLevel = logLevel
+		logger.Verbose(logLevel == "debug")
+	} else if verbose {
+		activeLogLevel = "debug"
+		logger.Verbose(true)
+	} else {
+		activeLogLevel = "info"
+		logger.Verbose(false)
+	}
+
+	// This has to come after activeLogLevel/logFormat are resolved above,
+	// since the "mindl plugin ..." subcommands' own output goes through
+	// the same logInfof/logJSON path as everything else and needs to
+	// respect both.
+	if flag.Arg(0) == "plugin" {
+		runPluginCommand(flag.Args()[1:])
+	}
+
 	// Ensure the path uses os.PathSeparator and ends with one.
 	dldir = strings.TrimSuffix(filepath.FromSlash(dldir), string(os.PathSeparator)) + string(os.PathSeparator)
 
-	if flag.NArg() == 0 {
+	var err error
+	urls, err = collectURLs(flag.Args())
+	if err != nil {
+		logFatal(err)
+	}
+
+	if len(urls) == 0 {
 		flag.Usage()
 		os.Exit(0)
 	}
 
+	state, err := LoadBatchState(dldir)
+	if err != nil {
+		logFatal(err)
+	}
+
+	sink, err := newSinkFromOutput(output, dldir, S3SinkConfig{
+		Endpoint:  s3Endpoint,
+		AccessKey: s3AccessKey,
+		SecretKey: s3SecretKey,
+		UseSSL:    s3UseSSL,
+	})
+	if err != nil {
+		logFatal(err)
+	}
+
+	if !forceRedo {
+		pending := make([]string, 0, len(urls))
+		for _, u := range urls {
+			if state.IsCompleted(u) {
+				logInfof("Skipping already completed URL: %s", u)
+				continue
+			}
+			pending = append(pending, u)
+		}
+		urls = pending
+	}
+
+	if len(urls) == 0 {
+		logInfo("Nothing to do, all URLs already completed.")
+		os.Exit(0)
+	}
+
 	pm := PluginManager(Plugins[:])
+	if dir, err := pluginDir(); err != nil {
+		logErrorf("Failed to locate external plugin directory: %v", err)
+	} else if err := pm.LoadExternal(dir); err != nil {
+		logErrorf("Failed to load external plugins: %v", err)
+	}
+
 	handlers := pm.FindHandlers(urls)
 	for i, h := range handlers {
 		// Ensure we have at least one handler for each URL.
 		if len(h) == 0 {
-			log.Errorf("Found no handler for: %s", urls[i])
+			logErrorf("Found no handler for: %s", urls[i])
 		}
 		// Set options for the plugin.
 		if err := pm.SetOptions(h, map[string]string(options), defaults, noprompt); err != nil {
-			log.Fatal(err)
+			logFatal(err)
 		}
 	}
 
@@ -149,52 +274,87 @@ func main() {
 		// can handle a URL.
 		// TODO: Make it possible to run mindl without user input.
 		if p, err := pm.SelectPlugin(h); err != nil {
-			log.Fatal(err)
+			logFatal(err)
 		} else {
 			// If we're dealing with multiple URLs, print which one we're processing.
 			if len(urls) > 1 {
-				log.Infof("Processing URL: %s", urls[i])
+				logInfof("Processing URL: %s", urls[i])
 			}
-			log.Infof("Starting download using \"%s\"...", pluginName(p))
-			startDownloading(urls[i], p)
+			logInfof("Starting download using \"%s\"...", pluginName(p))
+			startDownloading(urls[i], p, state, sink)
 		}
 	}
 }
 
-func startDownloading(url string, plugin plugins.Plugin) {
-	dm := NewDownloadManager(plugin, dldir)
-	lr, _ := minterm.NewLineReserver()
+func startDownloading(url string, plugin plugins.Plugin, state *BatchState, sink Sink) {
+	dm := NewDownloadManager(plugin, dldir, sink)
+	dm.Segments = segments
+	dm.MinSegmentSize = minSegmentSize
 	defer func() {
 		if r := recover(); r != nil {
-			log.Fatalf("Panicked: %v", r)
+			logFatalf("Panicked: %v", r)
 		}
 	}()
-	defer lr.Release()
 
-	// Get a new progress string and refresh the reserved line
-	// in regular intervals.
-	ticker := time.NewTicker(time.Millisecond * 500)
-	done := make(chan struct{})
-	defer func() {
-		ticker.Stop()
-		done <- struct{}{}
-	}()
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				lr.Set(dm.ProgressString())
-				lr.Refresh()
-			case <-done:
-				return
+	// The minterm progress line fights stdout redirection, so it's
+	// suppressed in favor of structured progress events when
+	// --log-format json is set.
+	if logFormat == "json" {
+		ticker := time.NewTicker(time.Millisecond * 500)
+		done := make(chan struct{})
+		defer func() {
+			ticker.Stop()
+			done <- struct{}{}
+		}()
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					done, total := dm.Progress()
+					logJSON("info", url, pluginName(plugin), "progress", &jsonProgress{Done: done, Total: total})
+				case <-done:
+					return
+				}
 			}
-		}
-	}()
+		}()
+	} else {
+		lr, _ := minterm.NewLineReserver()
+		defer lr.Release()
+
+		// Get a new progress string and refresh the reserved line
+		// in regular intervals.
+		ticker := time.NewTicker(time.Millisecond * 500)
+		done := make(chan struct{})
+		defer func() {
+			ticker.Stop()
+			done <- struct{}{}
+		}()
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					lr.Set(dm.ProgressString())
+					lr.Refresh()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
 
 	dls, err := dm.Download(url, workers, zipit, override)
 	if err != nil {
-		log.Error(err)
+		logEventf("error", url, pluginName(plugin), "%s", err)
 		return
 	}
-	log.Infof("Done! Got a total of %d downloads.", len(dls))
+
+	logEventf("info", url, pluginName(plugin), "Done! Got a total of %d downloads.", len(dls))
+
+	if err := sink.Finalize(); err != nil {
+		logEventf("error", url, pluginName(plugin), "Failed to finalize output sink: %v", err)
+	}
+
+	if err := state.MarkCompleted(url); err != nil {
+		logEventf("error", url, pluginName(plugin), "Failed to update resume state for %s: %v", url, err)
+	}
 }
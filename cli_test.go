@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectURLsDedup(t *testing.T) {
+	old := loadFromFile
+	defer func() { loadFromFile = old }()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "urls.txt")
+	if err := os.WriteFile(path, []byte("http://example.com/a\nhttp://example.com/c\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	loadFromFile = path
+
+	urls, err := collectURLs([]string{"http://example.com/a", "http://example.com/b"})
+	if err != nil {
+		t.Fatalf("collectURLs: %v", err)
+	}
+
+	want := []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}
+	if len(urls) != len(want) {
+		t.Fatalf("urls = %v, want %v", urls, want)
+	}
+	for i := range want {
+		if urls[i] != want[i] {
+			t.Errorf("urls[%d] = %q, want %q", i, urls[i], want[i])
+		}
+	}
+}
+
+func TestCollectURLsNoFile(t *testing.T) {
+	old := loadFromFile
+	defer func() { loadFromFile = old }()
+	loadFromFile = ""
+
+	args := []string{"http://example.com/a"}
+	urls, err := collectURLs(args)
+	if err != nil {
+		t.Fatalf("collectURLs: %v", err)
+	}
+	if len(urls) != 1 || urls[0] != args[0] {
+		t.Fatalf("urls = %v, want %v", urls, args)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+// mindl - A downloader for various sites and services.
+// Copyright (C) 2016  Mino <mino@minomino.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/MinoMino/mindl/plugins"
+)
+
+// DownloadManager drives plugin through fetching every file it yields
+// for a single URL, and writes the results to sink.
+type DownloadManager struct {
+	plugin plugins.Plugin
+	dldir  string
+	sink   Sink
+
+	// Segments is the number of concurrent ranged requests used to
+	// fetch each direct file URL the plugin yields. 1 disables
+	// segmentation.
+	Segments int
+	// MinSegmentSize is the smallest file, in bytes, worth segmenting.
+	MinSegmentSize int64
+
+	progress *SegmentedProgress
+}
+
+// NewDownloadManager returns a DownloadManager that downloads what
+// plugin yields for a URL into dldir before handing the finished files
+// to sink.
+func NewDownloadManager(plugin plugins.Plugin, dldir string, sink Sink) *DownloadManager {
+	return &DownloadManager{
+		plugin:         plugin,
+		dldir:          dldir,
+		sink:           sink,
+		Segments:       1,
+		MinSegmentSize: minSegmentSizeDefault,
+		progress:       &SegmentedProgress{},
+	}
+}
+
+// Progress returns the bytes fetched so far and the total size of the
+// current download, for the --log-format json progress events.
+func (dm *DownloadManager) Progress() (done, total int64) {
+	return dm.progress.Done(), dm.progress.Total()
+}
+
+// ProgressString renders Progress as a short line for the
+// minterm-reserved status line.
+func (dm *DownloadManager) ProgressString() string {
+	done, total := dm.Progress()
+	if total <= 0 {
+		return fmt.Sprintf("%d bytes", done)
+	}
+
+	return fmt.Sprintf("%d / %d bytes (%.1f%%)", done, total, float64(done)/float64(total)*100)
+}
+
+// Download fetches every file plugin.Process yields for url into a
+// scratch directory, then hands the results to dm.sink, zipping them
+// together first if zip is set. It returns the name of each file
+// written to the sink.
+func (dm *DownloadManager) Download(url string, workers int, zip bool, override bool) ([]string, error) {
+	items, err := dm.plugin.Process(url, workers, override)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dm.dldir, 0755); err != nil {
+		return nil, err
+	}
+
+	scratch, err := os.MkdirTemp(dm.dldir, ".mindl-dl-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scratch)
+
+	// The total is the sum of every item's size, known up front so it
+	// isn't clobbered by each item's own DownloadSegmented/
+	// downloadSingleStream call as the loop below moves from one file
+	// to the next. Items whose size can't be determined ahead of time
+	// are simply left out of it.
+	var total int64
+	for _, item := range items {
+		if size, ok := headSize(item.URL); ok {
+			total += size
+		}
+	}
+	dm.progress.SetTotal(total)
+
+	fetched := make([]string, 0, len(items))
+	for _, item := range items {
+		local := filepath.Join(scratch, filepath.Base(item.Name))
+		if err := DownloadSegmented(item.URL, local, dm.Segments, dm.MinSegmentSize, dm.progress); err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", item.URL, err)
+		}
+		fetched = append(fetched, local)
+	}
+
+	if zip {
+		return dm.zipToSink(fetched)
+	}
+
+	return dm.copyToSink(fetched)
+}
+
+// copyToSink writes each fetched file to the sink under its own base
+// name.
+func (dm *DownloadManager) copyToSink(paths []string) ([]string, error) {
+	names := make([]string, 0, len(paths))
+	for _, p := range paths {
+		name := filepath.Base(p)
+		if err := dm.writeOne(p, name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func (dm *DownloadManager) writeOne(localPath, name string) error {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := dm.sink.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	// The sink's Close is where e.g. S3Sink actually surfaces whether
+	// the upload succeeded, so its error can't be dropped via defer.
+	return dst.Close()
+}
+
+// zipToSink streams a ZIP archive of every fetched file directly into
+// the sink rather than building it on local disk first.
+func (dm *DownloadManager) zipToSink(paths []string) ([]string, error) {
+	const zipName = "download.zip"
+
+	w, err := dm.sink.Create(zipName)
+	if err != nil {
+		return nil, err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, p := range paths {
+		if err := addZipEntry(zw, p); err != nil {
+			zw.Close()
+			w.Close()
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return []string{zipName}, w.Close()
+}
+
+func addZipEntry(zw *zip.Writer, localPath string) error {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	entry, err := zw.Create(filepath.Base(localPath))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, src)
+	return err
+}
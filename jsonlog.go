@@ -0,0 +1,168 @@
+package main
+
+// mindl - A downloader for various sites and services.
+// Copyright (C) 2016  Mino <mino@minomino.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// validLogLevels are the values accepted by --log-level, in ascending
+// order of severity.
+var validLogLevels = []string{"debug", "info", "warn", "error"}
+
+// logLevelRank maps each valid level to its severity, used to compare
+// against activeLogLevel.
+var logLevelRank = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+// activeLogLevel is the minimum severity logJSON will emit. main() sets
+// it from --log-level, falling back to "debug" or "info" depending on
+// --verbose.
+var activeLogLevel = "info"
+
+func isValidLogLevel(level string) bool {
+	for _, l := range validLogLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// logLevelEnabled reports whether an event at level should be emitted
+// given the current activeLogLevel.
+func logLevelEnabled(level string) bool {
+	return logLevelRank[level] >= logLevelRank[activeLogLevel]
+}
+
+// jsonProgress mirrors a single download's progress for inclusion in a
+// structured log event.
+type jsonProgress struct {
+	Done  int64 `json:"done"`
+	Total int64 `json:"total"`
+}
+
+// jsonLogEvent is the shape written, one per line, when --log-format
+// json is set.
+type jsonLogEvent struct {
+	Time     string        `json:"time"`
+	Level    string        `json:"level"`
+	URL      string        `json:"url,omitempty"`
+	Plugin   string        `json:"plugin,omitempty"`
+	Msg      string        `json:"msg"`
+	Progress *jsonProgress `json:"progress,omitempty"`
+}
+
+// logJSON writes a single structured event to stdout. It's used instead
+// of the human-readable logger when --log-format json is active.
+func logJSON(level, url, plugin, msg string, progress *jsonProgress) {
+	if !logLevelEnabled(level) {
+		return
+	}
+
+	writeJSONEvent(level, url, plugin, msg, progress)
+}
+
+// writeJSONEvent marshals and prints a single event unconditionally,
+// bypassing logLevelEnabled. It's used both by logJSON and by output
+// that isn't a filterable log event but a command's actual result (e.g.
+// "mindl plugin list"), which --log-level must never suppress.
+func writeJSONEvent(level, url, plugin, msg string, progress *jsonProgress) {
+	event := jsonLogEvent{
+		Time:     time.Now().UTC().Format(time.RFC3339),
+		Level:    level,
+		URL:      url,
+		Plugin:   plugin,
+		Msg:      msg,
+		Progress: progress,
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to marshal log event: %v\n", err)
+		return
+	}
+
+	fmt.Println(string(data))
+}
+
+// logLine is the json/text dispatch shared by every log* helper below:
+// when --log-format json is active it emits a structured event via
+// logJSON, otherwise it falls back to the human-readable logger.
+func logLine(level, url, plugin, msg string) {
+	if logFormat == "json" {
+		logJSON(level, url, plugin, msg, nil)
+		return
+	}
+
+	if level == "error" {
+		log.Error(msg)
+	} else {
+		log.Info(msg)
+	}
+}
+
+// logInfo logs an info-level message that isn't tied to a specific URL
+// or plugin, routing it through logLine so every message (not just the
+// per-download progress events in startDownloading) respects
+// --log-format.
+func logInfo(msg string) {
+	logLine("info", "", "", msg)
+}
+
+// logInfof is logInfo with fmt.Sprintf-style formatting.
+func logInfof(format string, args ...interface{}) {
+	logInfo(fmt.Sprintf(format, args...))
+}
+
+// logErrorf is logInfo's error-level counterpart, with fmt.Sprintf-style
+// formatting.
+func logErrorf(format string, args ...interface{}) {
+	logLine("error", "", "", fmt.Sprintf(format, args...))
+}
+
+// logEventf is logLine with fmt.Sprintf-style formatting, for messages
+// tied to a specific URL and plugin, e.g. from startDownloading.
+func logEventf(level, url, plugin, format string, args ...interface{}) {
+	logLine(level, url, plugin, fmt.Sprintf(format, args...))
+}
+
+// logFatalMsg emits msg as a json error event and exits when
+// --log-format json is active, leaving the caller to fall back to
+// log.Fatal(f) (which itself terminates the process) otherwise.
+func logFatalMsg(msg string) {
+	if logFormat == "json" {
+		logJSON("error", "", "", msg, nil)
+		os.Exit(1)
+	}
+}
+
+// logFatal is logErrorf's counterpart for errors that should terminate
+// the process, same as log.Fatal.
+func logFatal(err error) {
+	logFatalMsg(err.Error())
+	log.Fatal(err)
+}
+
+// logFatalf is logFatal with fmt.Sprintf-style formatting.
+func logFatalf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	logFatalMsg(msg)
+	log.Fatal(msg)
+}
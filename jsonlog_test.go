@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+)
+
+// captureStdout runs fn with os.Stdout redirected and returns what it wrote.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	old := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+
+	fn()
+
+	w.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		t.Fatalf("io.Copy: %v", err)
+	}
+
+	return buf.String()
+}
+
+func TestLogInfofRoutesThroughJSONWhenLogFormatIsJSON(t *testing.T) {
+	oldFormat, oldLevel := logFormat, activeLogLevel
+	defer func() { logFormat, activeLogLevel = oldFormat, oldLevel }()
+	logFormat, activeLogLevel = "json", "info"
+
+	out := captureStdout(t, func() {
+		logInfof("hello %s", "world")
+	})
+
+	var event jsonLogEvent
+	if err := json.Unmarshal([]byte(out), &event); err != nil {
+		t.Fatalf("output %q isn't valid JSON: %v", out, err)
+	}
+	if event.Msg != "hello world" {
+		t.Errorf("Msg = %q, want %q", event.Msg, "hello world")
+	}
+	if event.Level != "info" {
+		t.Errorf("Level = %q, want %q", event.Level, "info")
+	}
+}
+
+func TestLogErrorfRespectsLogLevelInJSONMode(t *testing.T) {
+	oldFormat, oldLevel := logFormat, activeLogLevel
+	defer func() { logFormat, activeLogLevel = oldFormat, oldLevel }()
+	logFormat, activeLogLevel = "json", "error"
+
+	out := captureStdout(t, func() {
+		logInfof("should be suppressed below --log-level error")
+	})
+	if out != "" {
+		t.Errorf("logInfof wrote %q, want nothing below the active log level", out)
+	}
+
+	out = captureStdout(t, func() {
+		logErrorf("boom: %d", 42)
+	})
+	var event jsonLogEvent
+	if err := json.Unmarshal([]byte(out), &event); err != nil {
+		t.Fatalf("output %q isn't valid JSON: %v", out, err)
+	}
+	if event.Msg != "boom: 42" {
+		t.Errorf("Msg = %q, want %q", event.Msg, "boom: 42")
+	}
+}
+
+func TestWriteJSONEventIgnoresLogLevel(t *testing.T) {
+	oldFormat, oldLevel := logFormat, activeLogLevel
+	defer func() { logFormat, activeLogLevel = oldFormat, oldLevel }()
+	logFormat, activeLogLevel = "json", "error"
+
+	out := captureStdout(t, func() {
+		writeJSONEvent("info", "", "", "pixiv", nil)
+	})
+
+	var event jsonLogEvent
+	if err := json.Unmarshal([]byte(out), &event); err != nil {
+		t.Fatalf("output %q isn't valid JSON: %v", out, err)
+	}
+	if event.Msg != "pixiv" {
+		t.Errorf("Msg = %q, want %q", event.Msg, "pixiv")
+	}
+}
+
+func TestIsValidLogLevel(t *testing.T) {
+	for _, l := range []string{"debug", "info", "warn", "error"} {
+		if !isValidLogLevel(l) {
+			t.Errorf("isValidLogLevel(%q) = false, want true", l)
+		}
+	}
+
+	for _, l := range []string{"", "verbose", "ERROR", "trace"} {
+		if isValidLogLevel(l) {
+			t.Errorf("isValidLogLevel(%q) = true, want false", l)
+		}
+	}
+}
+
+func TestLogLevelEnabled(t *testing.T) {
+	old := activeLogLevel
+	defer func() { activeLogLevel = old }()
+
+	activeLogLevel = "warn"
+	cases := map[string]bool{
+		"debug": false,
+		"info":  false,
+		"warn":  true,
+		"error": true,
+	}
+	for level, want := range cases {
+		if got := logLevelEnabled(level); got != want {
+			t.Errorf("logLevelEnabled(%q) with activeLogLevel=warn = %v, want %v", level, got, want)
+		}
+	}
+}
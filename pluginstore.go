@@ -0,0 +1,365 @@
+package main
+
+// mindl - A downloader for various sites and services.
+// Copyright (C) 2016  Mino <mino@minomino.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"plugin"
+	"strings"
+
+	"github.com/MinoMino/mindl/plugins"
+)
+
+// defaultRegistryURL points at the manifest listing available external
+// plugins. Overridable with --registry for testing or private mirrors.
+const defaultRegistryURL = "https://mindl.minomino.org/plugins/manifest.json"
+
+// pluginDir returns the directory external plugins are installed into,
+// creating it if necessary.
+func pluginDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(home, ".mindl", "plugins")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// manifestCachePath returns where the locally cached copy of the
+// registry manifest is stored.
+func manifestCachePath() (string, error) {
+	dir, err := pluginDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "manifest.json"), nil
+}
+
+// ManifestEntry describes a single installable plugin as listed in the
+// registry manifest.
+type ManifestEntry struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	SHA256  string `json:"sha256"`
+}
+
+// Manifest is the JSON document served by the registry, listing every
+// plugin available for installation.
+type Manifest struct {
+	Plugins []ManifestEntry `json:"plugins"`
+}
+
+// fetchManifest downloads the manifest from registryURL and caches it
+// on disk for offline use by subsequent commands.
+func fetchManifest(registryURL string) (*Manifest, error) {
+	resp, err := http.Get(registryURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry returned status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	path, err := manifestCachePath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// loadCachedManifest reads the last manifest fetched with --update-index,
+// without hitting the network.
+func loadCachedManifest() (*Manifest, error) {
+	path, err := manifestCachePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.New("no cached manifest found, run \"mindl plugin update-index\" first")
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// findManifestEntry looks up a plugin by name in the manifest.
+func findManifestEntry(m *Manifest, name string) (ManifestEntry, error) {
+	for _, e := range m.Plugins {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+
+	return ManifestEntry{}, fmt.Errorf("no such plugin in the registry: %s", name)
+}
+
+// validatePluginName rejects names that aren't a bare filename component,
+// so a manifest entry (or a plugin name passed on the CLI) can't be used
+// to escape the plugin directory via "../" or an absolute path.
+func validatePluginName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid plugin name: %q", name)
+	}
+
+	return nil
+}
+
+// installPlugin downloads the .so for entry, verifies its checksum and
+// installs it into the local plugin directory.
+func installPlugin(entry ManifestEntry) error {
+	if err := validatePluginName(entry.Name); err != nil {
+		return err
+	}
+
+	resp, err := http.Get(entry.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: status %s", entry.Name, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != entry.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s, refusing to install", entry.Name)
+	}
+
+	dir, err := pluginDir()
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(dir, entry.Name+".so")
+	tmp := dest + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+// removePlugin deletes a previously installed external plugin.
+func removePlugin(name string) error {
+	if err := validatePluginName(name); err != nil {
+		return err
+	}
+
+	dir, err := pluginDir()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(filepath.Join(dir, name+".so"))
+}
+
+// listInstalledPlugins returns the names of plugins currently installed
+// in the local plugin directory.
+func listInstalledPlugins() ([]string, error) {
+	dir, err := pluginDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == ".so" {
+			names = append(names, e.Name()[:len(e.Name())-len(".so")])
+		}
+	}
+
+	return names, nil
+}
+
+// LoadExternal loads every .so plugin found in dir via plugin.Open and
+// appends the constructors it exports to pm. Each plugin must export a
+// "Plugin" symbol of type plugins.PluginConstructor.
+func (pm *PluginManager) LoadExternal(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".so" {
+			continue
+		}
+
+		path := filepath.Join(dir, e.Name())
+		p, err := plugin.Open(path)
+		if err != nil {
+			logErrorf("Failed to load external plugin %s: %v", e.Name(), err)
+			continue
+		}
+
+		sym, err := p.Lookup("Plugin")
+		if err != nil {
+			logErrorf("External plugin %s does not export \"Plugin\": %v", e.Name(), err)
+			continue
+		}
+
+		ctor, ok := sym.(plugins.PluginConstructor)
+		if !ok {
+			logErrorf("External plugin %s exports \"Plugin\" with the wrong type", e.Name())
+			continue
+		}
+
+		*pm = append(*pm, ctor)
+	}
+
+	return nil
+}
+
+// runPluginCommand handles "mindl plugin <subcommand> [args...]". Its
+// output goes through the same logInfo/logErrorf/logFatal family as the
+// rest of the CLI, so --log-format json covers it too.
+func runPluginCommand(args []string) {
+	if len(args) == 0 {
+		logFatal(errors.New("Usage: mindl plugin <install|remove|update|list|available> [name]"))
+	}
+
+	switch args[0] {
+	case "install":
+		if len(args) < 2 {
+			logFatal(errors.New("Usage: mindl plugin install <name>"))
+		}
+		m, err := loadCachedManifest()
+		if err != nil {
+			logFatal(err)
+		}
+		entry, err := findManifestEntry(m, args[1])
+		if err != nil {
+			logFatal(err)
+		}
+		if err := installPlugin(entry); err != nil {
+			logFatal(err)
+		}
+		logInfof("Installed plugin %s@%s", entry.Name, entry.Version)
+	case "remove":
+		if len(args) < 2 {
+			logFatal(errors.New("Usage: mindl plugin remove <name>"))
+		}
+		if err := removePlugin(args[1]); err != nil {
+			logFatal(err)
+		}
+		logInfof("Removed plugin %s", args[1])
+	case "update":
+		if len(args) < 2 {
+			logFatal(errors.New("Usage: mindl plugin update <name>"))
+		}
+		m, err := loadCachedManifest()
+		if err != nil {
+			logFatal(err)
+		}
+		entry, err := findManifestEntry(m, args[1])
+		if err != nil {
+			logFatal(err)
+		}
+		if err := installPlugin(entry); err != nil {
+			logFatal(err)
+		}
+		logInfof("Updated plugin %s to %s", entry.Name, entry.Version)
+	case "list":
+		names, err := listInstalledPlugins()
+		if err != nil {
+			logFatal(err)
+		}
+		for _, n := range names {
+			if logFormat == "json" {
+				// This is the command's actual result, not a
+				// filterable log event, so --log-level must not
+				// be able to suppress it.
+				writeJSONEvent("info", "", "", n, nil)
+			} else {
+				fmt.Println(n)
+			}
+		}
+	case "available":
+		m, err := loadCachedManifest()
+		if err != nil {
+			logFatal(err)
+		}
+		for _, e := range m.Plugins {
+			if logFormat == "json" {
+				writeJSONEvent("info", "", "", fmt.Sprintf("%s\t%s", e.Name, e.Version), nil)
+			} else {
+				fmt.Printf("%s\t%s\n", e.Name, e.Version)
+			}
+		}
+	case "update-index":
+		if _, err := fetchManifest(registryURL); err != nil {
+			logFatal(err)
+		}
+		logInfo("Updated the plugin registry index.")
+	default:
+		logFatalf("Unknown plugin subcommand: %s", args[0])
+	}
+
+	os.Exit(0)
+}
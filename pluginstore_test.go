@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestValidatePluginName(t *testing.T) {
+	valid := []string{"pixiv", "nhentai-v2", "my_plugin.1"}
+	for _, name := range valid {
+		if err := validatePluginName(name); err != nil {
+			t.Errorf("validatePluginName(%q) = %v, want nil", name, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"..",
+		"../evil",
+		"../../home/user/.bashrc",
+		"sub/dir",
+		"sub\\dir",
+		"/etc/passwd",
+	}
+	for _, name := range invalid {
+		if err := validatePluginName(name); err == nil {
+			t.Errorf("validatePluginName(%q) = nil, want error", name)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// rangeServer serves data at "/file", honoring Range requests with a 206
+// response the way a real ranged-download-capable server would. It
+// records every Range header it receives so tests can assert which
+// byte ranges were actually fetched.
+type rangeServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func newRangeServer(data []byte) *rangeServer {
+	rs := &rangeServer{}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			return
+		}
+
+		rangeHeader := r.Header.Get("Range")
+		if rangeHeader == "" {
+			w.Write(data)
+			return
+		}
+
+		rs.mu.Lock()
+		rs.ranges = append(rs.ranges, rangeHeader)
+		rs.mu.Unlock()
+
+		var start, end int64
+		if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end); err != nil {
+			http.Error(w, "bad range", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[start : end+1])
+	}))
+
+	return rs
+}
+
+func (rs *rangeServer) requestedRanges() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]string(nil), rs.ranges...)
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+func TestDownloadSegmentedFetchesWholeFile(t *testing.T) {
+	data := testData(12)
+	srv := newRangeServer(data)
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "out.bin")
+	progress := &SegmentedProgress{}
+	if err := DownloadSegmented(srv.URL+"/file", dest, 3, 1, progress); err != nil {
+		t.Fatalf("DownloadSegmented: %v", err)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("downloaded content = %v, want %v", got, data)
+	}
+	if done := progress.Done(); done != int64(len(data)) {
+		t.Errorf("progress.Done() = %d, want %d", done, len(data))
+	}
+	if _, err := os.Stat(partPath(dest)); !os.IsNotExist(err) {
+		t.Errorf("sidecar %s still exists after a successful download", partPath(dest))
+	}
+}
+
+func TestDownloadSegmentedResumesFromSidecar(t *testing.T) {
+	data := testData(12)
+	srv := newRangeServer(data)
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "out.bin")
+	url := srv.URL + "/file"
+
+	// Pre-populate dest with every byte a from-scratch download would
+	// eventually write, and a sidecar claiming segment 0 (bytes 0-3) is
+	// already fully fetched, segment 1 (bytes 4-7) is half fetched, and
+	// segment 2 (bytes 8-11) hasn't started.
+	if err := os.WriteFile(dest, data, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	state := &segmentState{
+		URL:  url,
+		Size: int64(len(data)),
+		Segments: []segmentRange{
+			{Start: 0, End: 3, Done: 4},
+			{Start: 4, End: 7, Done: 2},
+			{Start: 8, End: 11, Done: 0},
+		},
+	}
+	if err := state.save(dest); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	progress := &SegmentedProgress{}
+	if err := DownloadSegmented(url, dest, 3, 1, progress); err != nil {
+		t.Fatalf("DownloadSegmented: %v", err)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("downloaded content = %v, want %v", got, data)
+	}
+
+	// Segment 0 was already fully done, so it must never be requested
+	// again; segment 1 should resume from byte 6, not byte 4.
+	for _, rng := range srv.requestedRanges() {
+		if rng == "bytes=0-3" {
+			t.Errorf("segment 0 was already complete but got re-requested (%s)", rng)
+		}
+	}
+	wantSeg1 := "bytes=6-7"
+	foundSeg1 := false
+	for _, rng := range srv.requestedRanges() {
+		if rng == wantSeg1 {
+			foundSeg1 = true
+		}
+	}
+	if !foundSeg1 {
+		t.Errorf("requested ranges = %v, want one of them to be %q (resuming segment 1 from its Done offset)", srv.requestedRanges(), wantSeg1)
+	}
+
+	if _, err := os.Stat(partPath(dest)); !os.IsNotExist(err) {
+		t.Errorf("sidecar %s still exists after a successful resume", partPath(dest))
+	}
+}
+
+func TestDownloadSegmentedFallsBackWithoutRangeSupport(t *testing.T) {
+	data := testData(12)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No Accept-Ranges header, so canSegment should report false
+		// and DownloadSegmented must fall back to downloadSingleStream.
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "out.bin")
+	progress := &SegmentedProgress{}
+	if err := DownloadSegmented(srv.URL, dest, 3, 1, progress); err != nil {
+		t.Fatalf("DownloadSegmented: %v", err)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("downloaded content = %v, want %v", got, data)
+	}
+}
+
+func TestSplitRanges(t *testing.T) {
+	ranges := splitRanges(100, 3)
+	if len(ranges) != 3 {
+		t.Fatalf("len(ranges) = %d, want 3", len(ranges))
+	}
+
+	// Contiguous, no gaps or overlaps, and the last range reaches the end.
+	if ranges[0].Start != 0 {
+		t.Errorf("ranges[0].Start = %d, want 0", ranges[0].Start)
+	}
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].Start != ranges[i-1].End+1 {
+			t.Errorf("ranges[%d].Start = %d, want %d", i, ranges[i].Start, ranges[i-1].End+1)
+		}
+	}
+	if last := ranges[len(ranges)-1]; last.End != 99 {
+		t.Errorf("last range End = %d, want 99", last.End)
+	}
+}
+
+func TestSplitRangesSingleSegment(t *testing.T) {
+	ranges := splitRanges(50, 1)
+	if len(ranges) != 1 {
+		t.Fatalf("len(ranges) = %d, want 1", len(ranges))
+	}
+	if ranges[0].Start != 0 || ranges[0].End != 49 {
+		t.Errorf("ranges[0] = %+v, want {0 49 0}", ranges[0])
+	}
+}
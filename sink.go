@@ -0,0 +1,182 @@
+package main
+
+// mindl - A downloader for various sites and services.
+// Copyright (C) 2016  Mino <mino@minomino.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ErrInvalidOutput is returned when --output doesn't parse as a
+// recognized destination.
+var ErrInvalidOutput = errors.New("invalid --output destination")
+
+// Sink is where a DownloadManager writes the files it downloads. The
+// default is a FileSink rooted at dldir; --output s3://... swaps in an
+// S3Sink instead.
+type Sink interface {
+	// Create opens path (relative to the sink's root) for writing,
+	// creating any parent directories as needed.
+	Create(path string) (io.WriteCloser, error)
+	// Finalize is called once a download completes, after every file
+	// has been written and closed.
+	Finalize() error
+}
+
+// FileSink is the original, default Sink: it writes directly under a
+// local directory.
+type FileSink struct {
+	root string
+}
+
+// NewFileSink returns a Sink that writes under root.
+func NewFileSink(root string) *FileSink {
+	return &FileSink{root: root}
+}
+
+func (s *FileSink) Create(path string) (io.WriteCloser, error) {
+	full := filepath.Join(s.root, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return nil, err
+	}
+
+	return os.Create(full)
+}
+
+func (s *FileSink) Finalize() error {
+	return nil
+}
+
+// S3Sink writes files to an S3-compatible object store using
+// github.com/minio/minio-go/v7.
+type S3Sink struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// S3SinkConfig holds the credentials and endpoint needed to talk to an
+// S3-compatible store. Empty fields fall back to the standard
+// AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY / S3_ENDPOINT env vars.
+type S3SinkConfig struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// NewS3Sink creates a Sink that uploads into bucket/prefix on the
+// object store described by cfg.
+func NewS3Sink(bucket, prefix string, cfg S3SinkConfig) (*S3Sink, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("S3_ENDPOINT")
+	}
+	if endpoint == "" {
+		return nil, errors.New("no S3 endpoint given, use --s3-endpoint or $S3_ENDPOINT")
+	}
+
+	accessKey := cfg.AccessKey
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	secretKey := cfg.SecretKey
+	if secretKey == "" {
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: cfg.UseSSL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Sink{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// s3Writer buffers writes to a pipe and uploads the other end in the
+// background, so callers can treat it like any other WriteCloser.
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	return <-w.done
+}
+
+func (s *S3Sink) Create(path string) (io.WriteCloser, error) {
+	key := strings.TrimPrefix(filepath.ToSlash(filepath.Join(s.prefix, path)), "/")
+
+	pr, pw := io.Pipe()
+	w := &s3Writer{pw: pw, done: make(chan error, 1)}
+
+	go func() {
+		_, err := s.client.PutObject(context.Background(), s.bucket, key, pr,
+			-1, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w, nil
+}
+
+func (s *S3Sink) Finalize() error {
+	return nil
+}
+
+// newSinkFromOutput builds a Sink from the --output flag's value.
+// An empty output falls back to the default FileSink rooted at dldir.
+func newSinkFromOutput(output, dldir string, cfg S3SinkConfig) (Sink, error) {
+	if output == "" {
+		return NewFileSink(dldir), nil
+	}
+
+	if !strings.HasPrefix(output, "s3://") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidOutput, output)
+	}
+
+	rest := strings.TrimPrefix(output, "s3://")
+	parts := strings.SplitN(rest, "/", 2)
+	bucket := parts[0]
+	prefix := ""
+	if len(parts) == 2 {
+		prefix = parts[1]
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("%w: %q is missing a bucket name", ErrInvalidOutput, output)
+	}
+
+	return NewS3Sink(bucket, prefix, cfg)
+}
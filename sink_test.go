@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestNewSinkFromOutputDefaultsToFileSink(t *testing.T) {
+	sink, err := newSinkFromOutput("", "downloads/", S3SinkConfig{})
+	if err != nil {
+		t.Fatalf("newSinkFromOutput: %v", err)
+	}
+	if _, ok := sink.(*FileSink); !ok {
+		t.Fatalf("sink = %T, want *FileSink", sink)
+	}
+}
+
+func TestNewSinkFromOutputRejectsNonS3(t *testing.T) {
+	if _, err := newSinkFromOutput("ftp://example.com/bucket", "downloads/", S3SinkConfig{}); err == nil {
+		t.Fatal("expected an error for a non-s3:// --output, got nil")
+	}
+}
+
+func TestNewSinkFromOutputRejectsMissingBucket(t *testing.T) {
+	if _, err := newSinkFromOutput("s3://", "downloads/", S3SinkConfig{}); err == nil {
+		t.Fatal("expected an error for s3:// with no bucket, got nil")
+	}
+}
+
+func TestNewSinkFromOutputParsesS3(t *testing.T) {
+	sink, err := newSinkFromOutput("s3://mybucket/some/prefix", "downloads/", S3SinkConfig{Endpoint: "minio.local:9000"})
+	if err != nil {
+		t.Fatalf("newSinkFromOutput: %v", err)
+	}
+
+	s3sink, ok := sink.(*S3Sink)
+	if !ok {
+		t.Fatalf("sink = %T, want *S3Sink", sink)
+	}
+	if s3sink.bucket != "mybucket" {
+		t.Errorf("bucket = %q, want %q", s3sink.bucket, "mybucket")
+	}
+	if s3sink.prefix != "some/prefix" {
+		t.Errorf("prefix = %q, want %q", s3sink.prefix, "some/prefix")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+// mindl - A downloader for various sites and services.
+// Copyright (C) 2016  Mino <mino@minomino.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// stateFileName is the name of the JSON file, stored in the download
+// directory, that keeps track of which URLs have already completed.
+const stateFileName = ".mindl-state.json"
+
+// BatchState tracks completion of URLs across invocations so that
+// re-running mindl on the same batch with --load-from-file doesn't
+// redo work that already finished.
+type BatchState struct {
+	path      string
+	mu        sync.Mutex
+	Completed map[string]bool `json:"completed"`
+}
+
+// LoadBatchState reads the state file from dir, returning an empty
+// state if it doesn't exist yet.
+func LoadBatchState(dir string) (*BatchState, error) {
+	s := &BatchState{
+		path:      filepath.Join(dir, stateFileName),
+		Completed: make(map[string]bool),
+	}
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(s); err != nil {
+		return nil, err
+	}
+	if s.Completed == nil {
+		s.Completed = make(map[string]bool)
+	}
+	return s, nil
+}
+
+// IsCompleted reports whether url was already marked done in a
+// previous run.
+func (s *BatchState) IsCompleted(url string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Completed[url]
+}
+
+// MarkCompleted records url as done and persists the state file.
+func (s *BatchState) MarkCompleted(url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Completed[url] = true
+	return s.save()
+}
+
+// save writes the state to disk. Caller must hold s.mu.
+func (s *BatchState) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// readURLsFromFile reads one URL per line from path, ignoring blank
+// lines and lines starting with "#", and deduplicating entries while
+// preserving order.
+func readURLsFromFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	urls := make([]string, 0, 64)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+		urls = append(urls, line)
+	}
+
+	return urls, scanner.Err()
+}
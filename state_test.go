@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchStateLoadMarkResume(t *testing.T) {
+	dir := t.TempDir()
+
+	state, err := LoadBatchState(dir)
+	if err != nil {
+		t.Fatalf("LoadBatchState: %v", err)
+	}
+	if state.IsCompleted("http://example.com/a") {
+		t.Fatal("fresh state reports a URL as completed")
+	}
+
+	if err := state.MarkCompleted("http://example.com/a"); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	if !state.IsCompleted("http://example.com/a") {
+		t.Fatal("MarkCompleted did not take effect on the in-memory state")
+	}
+
+	// Simulate resuming in a new process by reloading from disk.
+	reloaded, err := LoadBatchState(dir)
+	if err != nil {
+		t.Fatalf("LoadBatchState (reload): %v", err)
+	}
+	if !reloaded.IsCompleted("http://example.com/a") {
+		t.Fatal("completion did not survive a reload from disk")
+	}
+	if reloaded.IsCompleted("http://example.com/b") {
+		t.Fatal("reloaded state reports an unrelated URL as completed")
+	}
+}
+
+func TestReadURLsFromFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "urls.txt")
+	content := "http://example.com/a\n# a comment\n\nhttp://example.com/b\nhttp://example.com/a\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	urls, err := readURLsFromFile(path)
+	if err != nil {
+		t.Fatalf("readURLsFromFile: %v", err)
+	}
+
+	want := []string{"http://example.com/a", "http://example.com/b"}
+	if len(urls) != len(want) {
+		t.Fatalf("urls = %v, want %v", urls, want)
+	}
+	for i := range want {
+		if urls[i] != want[i] {
+			t.Errorf("urls[%d] = %q, want %q", i, urls[i], want[i])
+		}
+	}
+}